@@ -0,0 +1,102 @@
+package loader
+
+import (
+	"context"
+	"sync"
+)
+
+// PluginEventType identifies a point in a plugin's lifecycle that other
+// parts of Grafana might want to react to without polling PluginErrors.
+type PluginEventType int
+
+const (
+	EventDiscovered PluginEventType = iota
+	EventSignatureValidated
+	EventSignatureRejected
+	EventRegistered
+	EventStarted
+	EventStopped
+	EventUnloaded
+	EventFailed
+)
+
+// PluginEvent is published whenever a plugin crosses one of the points
+// above. Reason is only populated for SignatureRejected and Failed.
+type PluginEvent struct {
+	PluginID string
+	Type     PluginEventType
+	Reason   string
+}
+
+// eventBufferSize bounds each subscriber's channel. Publish drops the
+// oldest buffered event rather than blocking the loader when a subscriber
+// falls behind.
+const eventBufferSize = 64
+
+// eventBus fans PluginEvents out to an arbitrary number of subscribers.
+// Subscribers that don't keep up lose their oldest unread events instead of
+// slowing down plugin loading.
+type eventBus struct {
+	mu          sync.Mutex
+	subscribers map[chan PluginEvent]struct{}
+}
+
+func newEventBus() *eventBus {
+	return &eventBus{subscribers: make(map[chan PluginEvent]struct{})}
+}
+
+func (b *eventBus) subscribe() (<-chan PluginEvent, func()) {
+	ch := make(chan PluginEvent, eventBufferSize)
+
+	b.mu.Lock()
+	b.subscribers[ch] = struct{}{}
+	b.mu.Unlock()
+
+	unsubscribe := func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := b.subscribers[ch]; ok {
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+	return ch, unsubscribe
+}
+
+func (b *eventBus) publish(event PluginEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+			// Subscriber is behind; drop its oldest buffered event to make
+			// room rather than block the loader on a slow consumer.
+			select {
+			case <-ch:
+			default:
+			}
+			select {
+			case ch <- event:
+			default:
+			}
+		}
+	}
+}
+
+// Subscribe returns a channel of PluginEvents published from loadPlugins,
+// load and unload - Discovered, SignatureValidated, SignatureRejected,
+// Registered, Started, Stopped, Unloaded and Failed. The channel is closed
+// once ctx is done. A slow consumer loses its oldest buffered events first;
+// it never blocks the loader.
+func (l *Loader) Subscribe(ctx context.Context) <-chan PluginEvent {
+	ch, unsubscribe := l.events.subscribe()
+
+	go func() {
+		<-ctx.Done()
+		unsubscribe()
+	}()
+
+	return ch
+}
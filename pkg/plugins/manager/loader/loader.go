@@ -20,6 +20,7 @@ import (
 	"github.com/grafana/grafana/pkg/plugins/manager/loader/assetpath"
 	"github.com/grafana/grafana/pkg/plugins/manager/loader/finder"
 	"github.com/grafana/grafana/pkg/plugins/manager/loader/initializer"
+	"github.com/grafana/grafana/pkg/plugins/manager/loader/manifest"
 	"github.com/grafana/grafana/pkg/plugins/manager/process"
 	"github.com/grafana/grafana/pkg/plugins/manager/registry"
 	"github.com/grafana/grafana/pkg/plugins/manager/signature"
@@ -44,48 +45,93 @@ type Loader struct {
 	pluginInitializer  initializer.Initializer
 	signatureValidator signature.Validator
 	pluginStorage      storage.Manager
+	pluginManifests    manifest.Store
 	pluginsCDN         *pluginscdn.Service
 	assetPath          *assetpath.Service
 	log                log.Logger
 	cfg                *config.Cfg
 
-	errs map[string]*plugins.SignatureError
+	pluginStates  *pluginStates
+	pluginLocks   *pluginLocks
+	acceptedPrivs *acceptedPrivileges
+	events        *eventBus
+
+	errs          map[string]*plugins.SignatureError
+	digestErrs    map[string]*plugins.Error
+	privilegeErrs map[string]*plugins.Error
 }
 
 func ProvideService(cfg *config.Cfg, license plugins.Licensing, authorizer plugins.PluginLoaderAuthorizer,
 	pluginRegistry registry.Service, backendProvider plugins.BackendFactoryProvider,
 	roleRegistry plugins.RoleRegistry, pluginsCDNService *pluginscdn.Service, assetPath *assetpath.Service) *Loader {
 	return New(cfg, license, authorizer, pluginRegistry, backendProvider, process.NewManager(pluginRegistry),
-		storage.FileSystem(log.NewPrettyLogger("loader.fs"), cfg.PluginsPath), roleRegistry, pluginsCDNService, assetPath)
+		storage.FileSystem(log.NewPrettyLogger("loader.fs"), cfg.PluginsPath),
+		manifest.NewFileStore(filepath.Join(cfg.PluginsPath, ".manifests")), roleRegistry, pluginsCDNService, assetPath)
 }
 
 func New(cfg *config.Cfg, license plugins.Licensing, authorizer plugins.PluginLoaderAuthorizer,
 	pluginRegistry registry.Service, backendProvider plugins.BackendFactoryProvider,
-	processManager process.Service, pluginStorage storage.Manager, roleRegistry plugins.RoleRegistry,
-	pluginsCDNService *pluginscdn.Service, assetPath *assetpath.Service) *Loader {
-	return &Loader{
+	processManager process.Service, pluginStorage storage.Manager, pluginManifests manifest.Store,
+	roleRegistry plugins.RoleRegistry, pluginsCDNService *pluginscdn.Service, assetPath *assetpath.Service) *Loader {
+	l := &Loader{
 		pluginFinder:       finder.New(),
 		pluginRegistry:     pluginRegistry,
 		pluginInitializer:  initializer.New(cfg, backendProvider, license),
 		signatureValidator: signature.NewValidator(authorizer),
 		processManager:     processManager,
 		pluginStorage:      pluginStorage,
+		pluginManifests:    pluginManifests,
 		errs:               make(map[string]*plugins.SignatureError),
+		digestErrs:         make(map[string]*plugins.Error),
+		privilegeErrs:      make(map[string]*plugins.Error),
 		log:                log.New("plugin.loader"),
 		roleRegistry:       roleRegistry,
 		cfg:                cfg,
 		pluginsCDN:         pluginsCDNService,
 		assetPath:          assetPath,
 	}
+	l.pluginStates = newPluginStates(l.log)
+	l.pluginLocks = newPluginLocks()
+	l.acceptedPrivs = newAcceptedPrivileges()
+	l.events = newEventBus()
+	l.seedAcceptedPrivilegesFromConfig(cfg)
+	return l
+}
+
+// LoadOption customizes a single call to Load.
+type LoadOption func(*loadOptions)
+
+type loadOptions struct {
+	aliases map[string]string // plugin path -> alias ID
+}
+
+// WithAlias loads the plugin found at path under id instead of its
+// declared plugin.json ID, similar to `docker plugin install --alias`.
+// This lets two configurations of the same plugin run side by side - e.g.
+// two versions during a migration - without forking it. The plugin's
+// declared ID is still used to verify its signature against MANIFEST.txt,
+// since that's what the manifest was signed against.
+func WithAlias(path, id string) LoadOption {
+	return func(o *loadOptions) {
+		if o.aliases == nil {
+			o.aliases = make(map[string]string)
+		}
+		o.aliases[path] = id
+	}
 }
 
-func (l *Loader) Load(ctx context.Context, class plugins.Class, paths []string) ([]*plugins.Plugin, error) {
+func (l *Loader) Load(ctx context.Context, class plugins.Class, paths []string, opts ...LoadOption) ([]*plugins.Plugin, error) {
 	pluginJSONPaths, err := l.pluginFinder.Find(paths)
 	if err != nil {
 		return nil, err
 	}
 
-	return l.loadPlugins(ctx, class, pluginJSONPaths)
+	var o loadOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	return l.loadPlugins(ctx, class, pluginJSONPaths, o.aliases)
 }
 
 func (l *Loader) createPluginsForLoading(class plugins.Class, foundPlugins foundPlugins) map[string]*plugins.Plugin {
@@ -97,6 +143,12 @@ func (l *Loader) createPluginsForLoading(class plugins.Class, foundPlugins found
 			continue
 		}
 
+		l.events.publish(PluginEvent{PluginID: plugin.ID, Type: EventDiscovered})
+
+		if err := l.pluginStates.transition(plugin.ID, StateInitializing); err != nil {
+			l.log.Warn("Invalid plugin state transition", "pluginID", plugin.ID, "err", err)
+		}
+
 		// calculate initial signature state
 		var sig plugins.Signature
 		if l.pluginsCDN.PluginSupported(plugin.ID) {
@@ -118,9 +170,22 @@ func (l *Loader) createPluginsForLoading(class plugins.Class, foundPlugins found
 	return loadedPlugins
 }
 
-func (l *Loader) loadPlugins(ctx context.Context, class plugins.Class, pluginJSONPaths []string) ([]*plugins.Plugin, error) {
+func (l *Loader) loadPlugins(ctx context.Context, class plugins.Class, pluginJSONPaths []string, aliases map[string]string) ([]*plugins.Plugin, error) {
 	var foundPlugins = foundPlugins{}
 
+	// resolve alias paths to the same absolute directory keying used below,
+	// so a caller-provided alias lines up with the plugin it was meant for
+	// regardless of how the path was spelled.
+	aliasesByDir := make(map[string]string, len(aliases))
+	for p, id := range aliases {
+		abs, err := filepath.Abs(p)
+		if err != nil {
+			l.log.Warn("Skipping alias for unresolvable path", "path", p, "alias", id, "err", err)
+			continue
+		}
+		aliasesByDir[abs] = id
+	}
+
 	// load plugin.json files and map directory to JSON data
 	for _, pluginJSONPath := range pluginJSONPaths {
 		plugin, err := l.readPluginJSON(pluginJSONPath)
@@ -148,9 +213,12 @@ func (l *Loader) loadPlugins(ctx context.Context, class plugins.Class, pluginJSO
 		registeredPlugins[p.ID] = struct{}{}
 	}
 
-	foundPlugins.stripDuplicates(registeredPlugins, l.log)
+	foundPlugins.stripDuplicates(registeredPlugins, aliasesByDir, l.log)
 
-	// create plugins structs and calculate signatures
+	// create plugins structs and calculate signatures, against each plugin's
+	// true declared ID - aliasesByDir is only applied once signature
+	// validation below has passed, so an alias can't be used to dodge the
+	// MANIFEST.txt check for the ID it was actually signed under
 	loadedPlugins := l.createPluginsForLoading(class, foundPlugins)
 
 	// wire up plugin dependencies
@@ -181,13 +249,76 @@ func (l *Loader) loadPlugins(ctx context.Context, class plugins.Class, pluginJSO
 				"pluginID", plugin.ID, "status", signingError.SignatureStatus)
 			plugin.SignatureError = signingError
 			l.errs[plugin.ID] = signingError
+			l.pluginStates.fail(plugin.ID, fmt.Sprintf("signature invalid: %s", signingError.SignatureStatus))
+			l.events.publish(PluginEvent{PluginID: plugin.ID, Type: EventSignatureRejected, Reason: string(signingError.SignatureStatus)})
 			// skip plugin so it will not be loaded any further
 			continue
 		}
+		l.events.publish(PluginEvent{PluginID: plugin.ID, Type: EventSignatureValidated})
 
 		// clear plugin error if a pre-existing error has since been resolved
 		delete(l.errs, plugin.ID)
 
+		// apply the caller-chosen alias now that signature validation has
+		// passed against the plugin's true declared ID - renaming any
+		// earlier than this would let an alias substitute a different ID
+		// into the MANIFEST.txt check than the one it was actually signed
+		// under.
+		if alias, ok := aliasesByDir[plugin.PluginDir]; ok && alias != "" && alias != plugin.ID {
+			originalID := plugin.ID
+			plugin.JSONData.ID = alias
+			if baseURL, err := l.assetPath.Base(plugin.JSONData, plugin.Class, plugin.PluginDir); err != nil {
+				l.log.Warn("Could not recompute base url for aliased plugin", "pluginID", originalID, "alias", alias, "err", err)
+			} else {
+				plugin.BaseURL = baseURL
+			}
+			if moduleURL, err := l.assetPath.Module(plugin.JSONData, plugin.Class, plugin.PluginDir); err != nil {
+				l.log.Warn("Could not recompute module url for aliased plugin", "pluginID", originalID, "alias", alias, "err", err)
+			} else {
+				plugin.Module = moduleURL
+			}
+			plugin.OriginalID = originalID
+			plugin.SetLogger(log.New(fmt.Sprintf("plugin.%s", plugin.ID)))
+		}
+
+		if plugin.IsExternalPlugin() {
+			if ok, digest, err := l.verifyPluginDigest(ctx, plugin); err != nil {
+				l.log.Warn("Could not verify plugin digest", "pluginID", plugin.ID, "err", err)
+			} else if !ok {
+				l.log.Error("Plugin contents do not match its recorded manifest, refusing to load",
+					"pluginID", plugin.ID, "path", plugin.PluginDir)
+				l.digestErrs[plugin.ID] = &plugins.Error{PluginID: plugin.ID, ErrorCode: plugins.ErrorCodeDigestMismatch}
+				l.pluginStates.fail(plugin.ID, "plugin contents do not match recorded manifest")
+				l.events.publish(PluginEvent{PluginID: plugin.ID, Type: EventFailed, Reason: "plugin contents do not match recorded manifest"})
+				continue
+			} else {
+				plugin.Digest = digest
+				delete(l.digestErrs, plugin.ID)
+			}
+		}
+
+		if plugin.IsExternalPlugin() {
+			required := l.Privileges(plugin.JSONData)
+			required.Unsigned = plugin.Signature == plugins.SignatureUnsigned
+			required.CommunitySigned = plugin.SignatureType == plugins.SignatureTypeCommunity
+
+			if required.RequiresAcceptance() {
+				accepted, ok := l.acceptedPrivs.get(plugin.ID)
+				if !ok || !privilegesAccepted(required, accepted) {
+					l.log.Warn("Refusing to load plugin with unaccepted privileges", "pluginID", plugin.ID)
+					l.privilegeErrs[plugin.ID] = &plugins.Error{PluginID: plugin.ID, ErrorCode: plugins.ErrorCodePrivilegesNotAccepted}
+					l.pluginStates.fail(plugin.ID, "required privileges have not been accepted")
+					l.events.publish(PluginEvent{PluginID: plugin.ID, Type: EventFailed, Reason: "required privileges have not been accepted"})
+					continue
+				}
+			}
+			delete(l.privilegeErrs, plugin.ID)
+		}
+
+		if err := l.pluginStates.transition(plugin.ID, StateInitialized); err != nil {
+			l.log.Warn("Invalid plugin state transition", "pluginID", plugin.ID, "err", err)
+		}
+
 		// verify module.js exists for SystemJS to load.
 		// CDN plugins can be loaded with plugin.json only, so do not warn for those.
 		if !plugin.IsRenderer() && !plugin.IsCorePlugin() {
@@ -206,18 +337,33 @@ func (l *Loader) loadPlugins(ctx context.Context, class plugins.Class, pluginJSO
 			setDefaultNavURL(plugin)
 		}
 
+		verifiedPlugins = append(verifiedPlugins, plugin)
+	}
+
+	// Only now that every plugin in this pass has had its alias applied (if
+	// any) is it safe to scope children to their parent - map iteration
+	// order above is random, so a child could otherwise be wired to its
+	// app parent's pre-alias ID/BaseURL/Module if visited first.
+	for _, plugin := range verifiedPlugins {
 		if plugin.Parent != nil && plugin.Parent.IsApp() {
 			configureAppChildPlugin(plugin.Parent, plugin)
 		}
-
-		verifiedPlugins = append(verifiedPlugins, plugin)
 	}
 
 	for _, p := range verifiedPlugins {
+		if err := l.pluginStates.transition(p.ID, StateInjecting); err != nil {
+			l.log.Warn("Invalid plugin state transition", "pluginID", p.ID, "err", err)
+		}
+
 		err := l.pluginInitializer.Initialize(ctx, p)
 		if err != nil {
+			l.pluginStates.fail(p.ID, err.Error())
+			l.events.publish(PluginEvent{PluginID: p.ID, Type: EventFailed, Reason: err.Error()})
 			return nil, err
 		}
+		if err := l.pluginStates.transition(p.ID, StateInjected); err != nil {
+			l.log.Warn("Invalid plugin state transition", "pluginID", p.ID, "err", err)
+		}
 		metrics.SetPluginBuildInformation(p.ID, string(p.Type), p.Info.Version, string(p.Signature))
 
 		if errDeclareRoles := l.roleRegistry.DeclarePluginRoles(ctx, p.ID, p.Name, p.Roles); errDeclareRoles != nil {
@@ -244,16 +390,19 @@ func (l *Loader) Unload(ctx context.Context, pluginID string) error {
 		return plugins.ErrUninstallCorePlugin
 	}
 
-	if err := l.unload(ctx, plugin); err != nil {
-		return err
-	}
-	return nil
+	return l.unload(ctx, plugin)
 }
 
 func (l *Loader) load(ctx context.Context, p *plugins.Plugin) error {
+	unlock := l.pluginLocks.lock(p.ID)
+	defer unlock()
+
 	if err := l.pluginRegistry.Add(ctx, p); err != nil {
+		l.pluginStates.fail(p.ID, err.Error())
+		l.events.publish(PluginEvent{PluginID: p.ID, Type: EventFailed, Reason: err.Error()})
 		return err
 	}
+	l.events.publish(PluginEvent{PluginID: p.ID, Type: EventRegistered})
 
 	if !p.IsCorePlugin() {
 		l.log.Info("Plugin registered", "pluginID", p.ID)
@@ -261,30 +410,59 @@ func (l *Loader) load(ctx context.Context, p *plugins.Plugin) error {
 
 	if p.IsExternalPlugin() {
 		if err := l.pluginStorage.Register(ctx, p.ID, p.PluginDir); err != nil {
+			l.pluginStates.fail(p.ID, err.Error())
+			l.events.publish(PluginEvent{PluginID: p.ID, Type: EventFailed, Reason: err.Error()})
 			return err
 		}
 	}
 
-	return l.processManager.Start(ctx, p.ID)
+	if err := l.pluginStates.transition(p.ID, StateStarting); err != nil {
+		l.log.Warn("Invalid plugin state transition", "pluginID", p.ID, "err", err)
+	}
+
+	if err := l.processManager.Start(ctx, p.ID); err != nil {
+		l.pluginStates.fail(p.ID, err.Error())
+		l.events.publish(PluginEvent{PluginID: p.ID, Type: EventFailed, Reason: err.Error()})
+		return err
+	}
+	l.events.publish(PluginEvent{PluginID: p.ID, Type: EventStarted})
+
+	return l.pluginStates.transition(p.ID, StateStarted)
 }
 
 func (l *Loader) unload(ctx context.Context, p *plugins.Plugin) error {
+	unlock := l.pluginLocks.lock(p.ID)
+	defer unlock()
+
+	if state, _, _, ok := l.State(p.ID); ok && (state == StateClosing || state == StateClosed) {
+		// already unloaded, or unloaded by a call that held the lock first
+		return plugins.ErrPluginNotInstalled
+	}
+
 	l.log.Debug("Stopping plugin process", "pluginId", p.ID)
 
+	if err := l.pluginStates.transition(p.ID, StateClosing); err != nil {
+		l.log.Warn("Invalid plugin state transition", "pluginID", p.ID, "err", err)
+	}
+
 	// TODO confirm the sequence of events is safe
 	if err := l.processManager.Stop(ctx, p.ID); err != nil {
+		l.pluginStates.fail(p.ID, err.Error())
+		l.events.publish(PluginEvent{PluginID: p.ID, Type: EventFailed, Reason: err.Error()})
 		return err
 	}
+	l.events.publish(PluginEvent{PluginID: p.ID, Type: EventStopped})
 
 	if err := l.pluginRegistry.Remove(ctx, p.ID); err != nil {
 		return err
 	}
 	l.log.Debug("Plugin unregistered", "pluginId", p.ID)
+	l.events.publish(PluginEvent{PluginID: p.ID, Type: EventUnloaded})
 
 	if err := l.pluginStorage.Remove(ctx, p.ID); err != nil {
 		return err
 	}
-	return nil
+	return l.pluginStates.transition(p.ID, StateClosed)
 }
 
 func (l *Loader) readPluginJSON(pluginJSONPath string) (plugins.JSONData, error) {
@@ -336,6 +514,11 @@ func (l *Loader) readPluginJSON(pluginJSONPath string) (plugins.JSONData, error)
 	return plugin, nil
 }
 
+// createPluginBase builds a plugins.Plugin from pluginJSON under its
+// declared ID. A caller-chosen alias, if any, is applied later in
+// loadPlugins once signature validation has passed against this true ID -
+// similar to `docker plugin install --alias`, but the rename can't happen
+// before the MANIFEST.txt check it would otherwise let an alias dodge.
 func (l *Loader) createPluginBase(pluginJSON plugins.JSONData, class plugins.Class, pluginDir string) (*plugins.Plugin, error) {
 	baseURL, err := l.assetPath.Base(pluginJSON, class, pluginDir)
 	if err != nil {
@@ -424,14 +607,74 @@ func defaultLogoPath(pluginType plugins.Type) string {
 	return "public/img/icn-" + string(pluginType) + ".svg"
 }
 
+// verifyPluginDigest computes p's content digest and checks it against the
+// manifest previously recorded for p.ID, if any. A manifest recorded for a
+// different version is treated as a legitimate upgrade rather than
+// tampering - its digest is expected to have changed along with the
+// version - so it's re-sealed with a fresh manifest instead of rejected. It
+// only persists a fresh manifest on first install or version bump; a load
+// that already matches the recorded manifest leaves it untouched, so the
+// manifest stays immutable across the plugin's ordinary lifecycle.
+func (l *Loader) verifyPluginDigest(ctx context.Context, p *plugins.Plugin) (bool, string, error) {
+	digest, err := l.pluginManifests.Digest(p.PluginDir)
+	if err != nil {
+		return false, "", fmt.Errorf("compute digest: %w", err)
+	}
+
+	ok, versionChanged, err := l.pluginManifests.Verify(ctx, p.ID, p.Info.Version, digest)
+	if err != nil {
+		return false, "", fmt.Errorf("verify manifest: %w", err)
+	}
+	if !ok {
+		return false, "", nil
+	}
+
+	if versionChanged {
+		if err := l.pluginManifests.Write(ctx, manifest.Manifest{
+			PluginID:      p.ID,
+			Version:       p.Info.Version,
+			Digest:        digest,
+			SignatureType: p.SignatureType,
+			SignatureOrg:  p.SignatureOrg,
+		}); err != nil {
+			return false, "", fmt.Errorf("persist manifest: %w", err)
+		}
+	}
+
+	return true, digest, nil
+}
+
 func (l *Loader) PluginErrors() []*plugins.Error {
-	errs := make([]*plugins.Error, 0)
+	errs := make([]*plugins.Error, 0, len(l.errs)+len(l.digestErrs)+len(l.privilegeErrs))
 	for _, err := range l.errs {
 		errs = append(errs, &plugins.Error{
 			PluginID:  err.PluginID,
 			ErrorCode: err.AsErrorCode(),
 		})
 	}
+	for _, err := range l.digestErrs {
+		errs = append(errs, err)
+	}
+	for _, err := range l.privilegeErrs {
+		errs = append(errs, err)
+	}
+
+	// surface plugins stuck in a failed state (e.g. hung in Initializing due
+	// to a backend factory) that aren't already covered by a more specific
+	// signature, digest or privileges error above; the failure reason
+	// itself is available to operators via State(), not on plugins.Error.
+	for pluginID := range l.pluginStates.failed() {
+		if _, ok := l.errs[pluginID]; ok {
+			continue
+		}
+		if _, ok := l.digestErrs[pluginID]; ok {
+			continue
+		}
+		if _, ok := l.privilegeErrs[pluginID]; ok {
+			continue
+		}
+		errs = append(errs, &plugins.Error{PluginID: pluginID, ErrorCode: plugins.ErrorCodePluginFailed})
+	}
 
 	return errs
 }
@@ -445,16 +688,24 @@ func validatePluginJSON(data plugins.JSONData) error {
 
 type foundPlugins map[string]plugins.JSONData
 
-// stripDuplicates will strip duplicate plugins or plugins that already exist
-func (f *foundPlugins) stripDuplicates(existingPlugins map[string]struct{}, log log.Logger) {
+// stripDuplicates will strip duplicate plugins or plugins that already
+// exist. A plugin found under an alias (see WithAlias) is keyed by that
+// alias rather than its declared plugin.json ID, so two configurations of
+// the same plugin can coexist as long as they're given different aliases.
+func (f *foundPlugins) stripDuplicates(existingPlugins map[string]struct{}, aliases map[string]string, log log.Logger) {
 	pluginsByID := make(map[string]struct{})
 	for k, scannedPlugin := range *f {
-		if _, existing := existingPlugins[scannedPlugin.ID]; existing {
-			log.Debug("Skipping plugin as it's already installed", "plugin", scannedPlugin.ID)
+		id := scannedPlugin.ID
+		if alias, ok := aliases[k]; ok {
+			id = alias
+		}
+
+		if _, existing := existingPlugins[id]; existing {
+			log.Debug("Skipping plugin as it's already installed", "plugin", id)
 			delete(*f, k)
 			continue
 		}
 
-		pluginsByID[scannedPlugin.ID] = struct{}{}
+		pluginsByID[id] = struct{}{}
 	}
 }
@@ -0,0 +1,175 @@
+// Package manifest records content digests for installed plugins: every
+// plugin version is identified by the sha256 digest of its contents, and a
+// manifest records which digest, version and signature a plugin directory
+// is supposed to contain. This gives the loader tamper detection that's
+// independent of the MANIFEST.txt signature check. A manifest is replaced
+// with an atomic rename. The plugin files themselves stay in the plugin
+// directory; they are not stored as blobs keyed by their digest.
+package manifest
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/grafana/grafana/pkg/plugins"
+)
+
+// Manifest is the immutable record of a single installed plugin version.
+// It's written once, after a plugin's digest has been computed and its
+// signature validated, and is never mutated in place - a new version gets
+// a new Manifest rather than an edit to this one.
+type Manifest struct {
+	PluginID      string                `json:"pluginId"`
+	Version       string                `json:"version"`
+	Digest        string                `json:"digest"`
+	SignatureType plugins.SignatureType `json:"signatureType"`
+	SignatureOrg  string                `json:"signatureOrg"`
+}
+
+// Store computes and persists Manifests, and verifies that a plugin
+// directory's contents still match the digest recorded for it.
+type Store interface {
+	// Digest returns the sha256 digest of plugin.json plus every other file
+	// under pluginDir, computed in a deterministic order so the result
+	// doesn't depend on file system iteration order.
+	Digest(pluginDir string) (string, error)
+
+	// Write persists m as the current manifest for m.PluginID.
+	Write(ctx context.Context, m Manifest) error
+
+	// Verify reports whether digest still matches the manifest previously
+	// written for pluginID at version. A plugin with no manifest on record
+	// yet (first install) is reported as verified, since there's nothing to
+	// have tampered with. A manifest recorded for a different version is
+	// also reported as verified - a version bump legitimately changes the
+	// digest, so it's a re-seal rather than tampering. Only a digest
+	// mismatch within the same recorded version is tampering.
+	//
+	// versionChanged reports whether there's a new Manifest for the caller
+	// to Write: true for first install and for a version bump, false when
+	// the existing Manifest already matches and nothing needs persisting.
+	Verify(ctx context.Context, pluginID, version, digest string) (matched, versionChanged bool, err error)
+}
+
+// FileStore is a Store backed by a flat directory of one JSON file per
+// plugin ID, kept in a directory of its own (ProvideService uses .manifests
+// under the plugins path) rather than inside any plugin's own directory, so
+// a plugin can't tamper with its own manifest.
+type FileStore struct {
+	manifestsDir string
+}
+
+// NewFileStore returns a FileStore that reads and writes manifests under
+// manifestsDir, creating it on first write if it doesn't exist yet.
+func NewFileStore(manifestsDir string) *FileStore {
+	return &FileStore{manifestsDir: manifestsDir}
+}
+
+func (s *FileStore) Digest(pluginDir string) (string, error) {
+	var paths []string
+	err := filepath.WalkDir(pluginDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		paths = append(paths, p)
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("walk plugin dir: %w", err)
+	}
+	sort.Strings(paths)
+
+	h := sha256.New()
+	for _, p := range paths {
+		rel, err := filepath.Rel(pluginDir, p)
+		if err != nil {
+			return "", fmt.Errorf("relative path: %w", err)
+		}
+		// A NUL separates the path from its contents so that, e.g., path
+		// "ab" with empty contents can't hash the same as path "a" with
+		// contents "b" - file paths can't contain NUL, so this is
+		// unambiguous.
+		if _, err := io.WriteString(h, rel); err != nil {
+			return "", err
+		}
+		if _, err := h.Write([]byte{0}); err != nil {
+			return "", err
+		}
+
+		// nolint:gosec
+		// p is derived from walking pluginDir on disk, not user input.
+		f, err := os.Open(p)
+		if err != nil {
+			return "", fmt.Errorf("open %s: %w", rel, err)
+		}
+		_, copyErr := io.Copy(h, f)
+		closeErr := f.Close()
+		if copyErr != nil {
+			return "", fmt.Errorf("hash %s: %w", rel, copyErr)
+		}
+		if closeErr != nil {
+			return "", fmt.Errorf("close %s: %w", rel, closeErr)
+		}
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func (s *FileStore) path(pluginID string) string {
+	return filepath.Join(s.manifestsDir, pluginID+".json")
+}
+
+func (s *FileStore) Write(_ context.Context, m Manifest) error {
+	if err := os.MkdirAll(s.manifestsDir, 0750); err != nil {
+		return fmt.Errorf("create manifests dir: %w", err)
+	}
+
+	b, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("marshal manifest: %w", err)
+	}
+
+	// Write to a temp file and rename into place so a crash mid-write can
+	// never leave a subsequent Verify reading a half-written manifest; a
+	// reinstall or upgrade replaces the whole manifest file at once.
+	tmp := s.path(m.PluginID) + ".tmp"
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		return fmt.Errorf("write manifest: %w", err)
+	}
+	if err := os.Rename(tmp, s.path(m.PluginID)); err != nil {
+		return fmt.Errorf("rename manifest into place: %w", err)
+	}
+	return nil
+}
+
+func (s *FileStore) Verify(_ context.Context, pluginID, version, digest string) (bool, bool, error) {
+	b, err := os.ReadFile(s.path(pluginID))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return true, true, nil
+		}
+		return false, false, fmt.Errorf("read manifest: %w", err)
+	}
+
+	var m Manifest
+	if err := json.Unmarshal(b, &m); err != nil {
+		return false, false, fmt.Errorf("unmarshal manifest: %w", err)
+	}
+
+	if m.Version != version {
+		return true, true, nil
+	}
+
+	return digest == m.Digest, false, nil
+}
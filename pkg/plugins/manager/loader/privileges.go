@@ -0,0 +1,175 @@
+package loader
+
+import (
+	"fmt"
+	"net/url"
+	"sync"
+
+	"github.com/grafana/grafana/pkg/plugins"
+	"github.com/grafana/grafana/pkg/plugins/config"
+)
+
+// PluginPrivileges is the set of capabilities a plugin will be granted if
+// it's loaded: the roles it declares, whether it runs backend/executable
+// code, any external service it registers, the hosts its proxy routes will
+// reach, and whether it's unsigned or community-signed. It's computed from
+// a plugin's plugin.json (plus, for the signature tier, its calculated
+// plugins.Signature) before the plugin is ever registered, so an operator -
+// or a static config allowlist - can review and accept it first.
+type PluginPrivileges struct {
+	Roles           []plugins.RoleRegistration
+	Backend         bool
+	Executable      bool
+	ExternalService bool
+	AllowedHosts    []string
+	Unsigned        bool
+	CommunitySigned bool
+
+	// AcceptAll marks an accepted-privileges entry as covering whatever a
+	// plugin declares, without a field-by-field comparison. It's only ever
+	// set on the accepted side (e.g. an operator allowlisting a plugin ID
+	// via config), never on a plugin's computed, required Privileges.
+	AcceptAll bool
+}
+
+// RequiresAcceptance reports whether loadPlugins must find p in the
+// accepted-privileges set before registering the plugin. Grafana- and
+// commercially-signed plugins already go through Grafana's own review before
+// they're signed, so this only gates the unsigned/community tier the
+// privileges check targets: an unsigned plugin always requires acceptance,
+// and a community-signed one does if it declares roles, an external
+// service, or allowed hosts beyond its own frontend. A signed plugin
+// declaring the same things doesn't - that's the normal shape of a
+// datasource/app plugin, not something to brick on every install.
+func (p PluginPrivileges) RequiresAcceptance() bool {
+	if p.Unsigned {
+		return true
+	}
+	if !p.CommunitySigned {
+		return false
+	}
+	return len(p.Roles) > 0 || p.ExternalService || len(p.AllowedHosts) > 0
+}
+
+// Privileges inspects pluginJSON and reports the capabilities the plugin
+// will be granted if it's loaded, without loading it. It sees only
+// plugin.json, so Unsigned and CommunitySigned are always false in its
+// result; loadPlugins sets them from the plugin's calculated signature
+// before comparing against what was accepted. Callers are expected
+// to show the result to an admin (or compare it against a config
+// allowlist) and call AcceptPrivileges before the plugin can be registered.
+func (l *Loader) Privileges(pluginJSON plugins.JSONData) PluginPrivileges {
+	p := PluginPrivileges{
+		Roles:           pluginJSON.Roles,
+		Backend:         pluginJSON.Backend,
+		Executable:      pluginJSON.Executable != "",
+		ExternalService: pluginJSON.ExternalServiceRegistration != nil,
+	}
+
+	for _, route := range pluginJSON.Routes {
+		if route == nil || route.URL == "" {
+			continue
+		}
+		u, err := url.Parse(route.URL)
+		if err != nil || u.Host == "" {
+			continue
+		}
+		p.AllowedHosts = append(p.AllowedHosts, u.Host)
+	}
+
+	return p
+}
+
+// roleKey identifies a declared role for privilege-acceptance comparisons.
+// RoleRegistration has no natural string identity of its own, so its
+// formatted representation is used as a stand-in key.
+func roleKey(r plugins.RoleRegistration) string {
+	return fmt.Sprintf("%+v", r)
+}
+
+// privilegesAccepted reports whether everything required is covered by
+// what was accepted: every declared role, every allowed host, and the
+// external-service/unsigned/community flags.
+func privilegesAccepted(required, accepted PluginPrivileges) bool {
+	if accepted.AcceptAll {
+		return true
+	}
+
+	if required.ExternalService && !accepted.ExternalService {
+		return false
+	}
+	if required.Unsigned && !accepted.Unsigned {
+		return false
+	}
+	if required.CommunitySigned && !accepted.CommunitySigned {
+		return false
+	}
+
+	acceptedRoles := make(map[string]struct{}, len(accepted.Roles))
+	for _, r := range accepted.Roles {
+		acceptedRoles[roleKey(r)] = struct{}{}
+	}
+	for _, r := range required.Roles {
+		if _, ok := acceptedRoles[roleKey(r)]; !ok {
+			return false
+		}
+	}
+
+	acceptedHosts := make(map[string]struct{}, len(accepted.AllowedHosts))
+	for _, h := range accepted.AllowedHosts {
+		acceptedHosts[h] = struct{}{}
+	}
+	for _, h := range required.AllowedHosts {
+		if _, ok := acceptedHosts[h]; !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+// acceptedPrivileges records, per plugin ID, the privileges an operator (or
+// a static config allowlist) has explicitly accepted for a pending install.
+// loadPlugins consults it before registering any external plugin whose
+// computed Privileges require acceptance.
+type acceptedPrivileges struct {
+	mu       sync.RWMutex
+	accepted map[string]PluginPrivileges
+}
+
+func newAcceptedPrivileges() *acceptedPrivileges {
+	return &acceptedPrivileges{accepted: make(map[string]PluginPrivileges)}
+}
+
+func (a *acceptedPrivileges) get(pluginID string) (PluginPrivileges, bool) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	p, ok := a.accepted[pluginID]
+	return p, ok
+}
+
+func (a *acceptedPrivileges) set(pluginID string, p PluginPrivileges) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.accepted[pluginID] = p
+}
+
+// AcceptPrivileges records that the privileges Privileges computed for
+// pluginID have been explicitly accepted - typically by an admin reviewing
+// them through the plugin installation API, or by a static allowlist read
+// from config at startup. Until this has been called, loadPlugins refuses
+// to register an external plugin whose privileges require acceptance.
+func (l *Loader) AcceptPrivileges(pluginID string, p PluginPrivileges) {
+	l.acceptedPrivs.set(pluginID, p)
+}
+
+// seedAcceptedPrivilegesFromConfig accepts every plugin ID the operator has
+// already allowlisted through config (the same `allow_loading_unsigned_plugins`
+// list the signature validator itself consults), so plugins that were
+// previously loadable under that setting don't start failing on upgrade
+// just because the privileges gate was introduced.
+func (l *Loader) seedAcceptedPrivilegesFromConfig(cfg *config.Cfg) {
+	for _, pluginID := range cfg.PluginsAllowUnsigned {
+		l.acceptedPrivs.set(pluginID, PluginPrivileges{AcceptAll: true})
+	}
+}
@@ -0,0 +1,223 @@
+package loader
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana/pkg/infra/metrics"
+	"github.com/grafana/grafana/pkg/plugins/log"
+)
+
+// PluginState is a point in a plugin's lifecycle, as tracked by the loader.
+// Plugins normally move through these states in order; Failed is reachable
+// from any non-terminal state.
+type PluginState int
+
+const (
+	StateLoading PluginState = iota
+	StateInitializing
+	StateInitialized
+	StateInjecting
+	StateInjected
+	StateStarting
+	StateStarted
+	StateClosing
+	StateClosed
+	StateFailed
+)
+
+func (s PluginState) String() string {
+	switch s {
+	case StateLoading:
+		return "loading"
+	case StateInitializing:
+		return "initializing"
+	case StateInitialized:
+		return "initialized"
+	case StateInjecting:
+		return "injecting"
+	case StateInjected:
+		return "injected"
+	case StateStarting:
+		return "starting"
+	case StateStarted:
+		return "started"
+	case StateClosing:
+		return "closing"
+	case StateClosed:
+		return "closed"
+	case StateFailed:
+		return "failed"
+	default:
+		return "unknown"
+	}
+}
+
+// validNextStates enumerates the transitions allowed out of each state.
+// Failed is reachable from anywhere except Closed/Failed itself, so it's
+// handled separately in canTransition rather than repeated in every entry.
+var validNextStates = map[PluginState][]PluginState{
+	StateLoading:      {StateInitializing},
+	StateInitializing: {StateInitialized},
+	StateInitialized:  {StateInjecting},
+	StateInjecting:    {StateInjected},
+	StateInjected:     {StateStarting},
+	StateStarting:     {StateStarted},
+	StateStarted:      {StateClosing},
+	StateClosing:      {StateClosed},
+	StateClosed:       {StateInitializing}, // a plugin can be loaded again after being closed
+	StateFailed:       {StateInitializing}, // a plugin can be retried on a later reload pass
+}
+
+func canTransition(from, to PluginState) bool {
+	if to == StateFailed {
+		return from != StateClosed && from != StateFailed
+	}
+	for _, s := range validNextStates[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// pluginStateEntry is the state-machine bookkeeping the loader keeps for a
+// single plugin, keyed by plugin ID.
+type pluginStateEntry struct {
+	state        PluginState
+	transitioned time.Time
+	reason       string
+}
+
+// pluginStates guards concurrent lifecycle transitions for every plugin the
+// loader knows about. It only protects each individual transition - see
+// pluginLocks for the lock that guards a whole load/unload side effect.
+type pluginStates struct {
+	mu      sync.Mutex
+	entries map[string]*pluginStateEntry
+	log     log.Logger
+}
+
+func newPluginStates(log log.Logger) *pluginStates {
+	return &pluginStates{
+		entries: make(map[string]*pluginStateEntry),
+		log:     log,
+	}
+}
+
+// transition moves pluginID to next, entering it at StateLoading first if
+// this is the first time the loader has seen it. It returns an error, and
+// leaves the recorded state unchanged, if next isn't a valid transition from
+// wherever the plugin currently is.
+func (p *pluginStates) transition(pluginID string, next PluginState) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	entry, ok := p.entries[pluginID]
+	if !ok {
+		entry = &pluginStateEntry{state: StateLoading, transitioned: time.Now()}
+		p.entries[pluginID] = entry
+		p.log.Debug("Plugin state transition", "pluginID", pluginID, "state", StateLoading)
+		metrics.SetPluginState(pluginID, StateLoading.String())
+		if next == StateLoading {
+			return nil
+		}
+	}
+
+	if !canTransition(entry.state, next) {
+		return fmt.Errorf("plugin %s: invalid state transition from %s to %s", pluginID, entry.state, next)
+	}
+
+	entry.state = next
+	entry.transitioned = time.Now()
+	if next != StateFailed {
+		entry.reason = ""
+	}
+	p.log.Debug("Plugin state transition", "pluginID", pluginID, "state", next)
+	metrics.SetPluginState(pluginID, next.String())
+	return nil
+}
+
+// fail forcibly moves pluginID into StateFailed and records why, regardless
+// of its current state (short of it already being Closed or Failed).
+func (p *pluginStates) fail(pluginID, reason string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	entry, ok := p.entries[pluginID]
+	if !ok {
+		entry = &pluginStateEntry{}
+		p.entries[pluginID] = entry
+	} else if entry.state == StateClosed || entry.state == StateFailed {
+		return
+	}
+
+	entry.state = StateFailed
+	entry.transitioned = time.Now()
+	entry.reason = reason
+	p.log.Warn("Plugin entered failed state", "pluginID", pluginID, "reason", reason)
+	metrics.SetPluginState(pluginID, StateFailed.String())
+}
+
+func (p *pluginStates) get(pluginID string) (state PluginState, transitioned time.Time, reason string, ok bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	entry, ok := p.entries[pluginID]
+	if !ok {
+		return 0, time.Time{}, "", false
+	}
+	return entry.state, entry.transitioned, entry.reason, true
+}
+
+// failed returns the failure reason recorded for every plugin currently in
+// StateFailed, keyed by plugin ID.
+func (p *pluginStates) failed() map[string]string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	reasons := make(map[string]string)
+	for id, entry := range p.entries {
+		if entry.state == StateFailed {
+			reasons[id] = entry.reason
+		}
+	}
+	return reasons
+}
+
+// State returns the current lifecycle state of pluginID, the time of its
+// last transition, and - if it's Failed - why. The final bool reports
+// whether the loader knows about this plugin at all.
+func (l *Loader) State(pluginID string) (state PluginState, transitioned time.Time, reason string, ok bool) {
+	return l.pluginStates.get(pluginID)
+}
+
+// pluginLocks hands out a per-plugin-ID mutex so load and unload can hold a
+// single lock across their whole registry/process side effect, not just
+// each individual state transition. Without it, a re-entrant Load racing an
+// Unload for the same plugin ID could interleave their Start/Stop calls
+// even though each transition on its own is guarded by pluginStates.
+type pluginLocks struct {
+	mu    sync.Mutex
+	locks map[string]*sync.Mutex
+}
+
+func newPluginLocks() *pluginLocks {
+	return &pluginLocks{locks: make(map[string]*sync.Mutex)}
+}
+
+// lock acquires pluginID's lock, creating it on first use, and returns a
+// func to release it.
+func (p *pluginLocks) lock(pluginID string) func() {
+	p.mu.Lock()
+	pluginMu, ok := p.locks[pluginID]
+	if !ok {
+		pluginMu = &sync.Mutex{}
+		p.locks[pluginID] = pluginMu
+	}
+	p.mu.Unlock()
+
+	pluginMu.Lock()
+	return pluginMu.Unlock
+}